@@ -62,8 +62,8 @@ func (cmd *infoCollector) Init(c *finch.CommandState, f *finch.Finch) error {
 	cmd.CommandState = c
 	cmd.Finch = f
 
-	stored := cmd.Get("stats")
-	if stored == nil {
+	stored, ok := cmd.Get("stats")
+	if !ok {
 		userMessages = make(UserMessageCount)
 	} else {
 		for user, count := range stored.(map[string]interface{}) {
@@ -89,7 +89,5 @@ func (cmd *infoCollector) Execute(update tgbotapi.Update) error {
 		userMessages[update.Message.From.String()] += 1
 	}
 
-	cmd.CommandBase.Set("stats", userMessages)
-
-	return nil
+	return cmd.CommandBase.Set("stats", userMessages)
 }
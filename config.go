@@ -0,0 +1,270 @@
+package finch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getsentry/raven-go"
+)
+
+// Config is a typed store for configuration information.
+//
+// GetString, GetBool, and GetInt each first consult an environment
+// variable before falling back to the backing store, mirroring the
+// TELEGRAM_APITOKEN / DEBUG style overrides bots already accept. The
+// env var name is "FINCH_" followed by the key, upper-cased with dots
+// turned into underscores; in a Config returned by Namespace the key
+// carries the namespace prefix, so Namespace("stats").GetInt("count")
+// reads FINCH_STATS_COUNT. Get does not, since an
+// env var can only ever hold a string and keys storing structured data
+// need their stored type back untouched.
+type Config interface {
+	// Get returns the raw value stored under key, and whether it was set.
+	Get(key string) (interface{}, bool)
+	// GetString returns the value stored under key as a string.
+	GetString(key string) (string, bool)
+	// GetBool returns the value stored under key as a bool. Missing or
+	// unparsable values are treated as false.
+	GetBool(key string) bool
+	// GetInt returns the value stored under key as an int. Missing or
+	// unparsable values are treated as 0.
+	GetInt(key string) int
+	// Set stores v under key and persists the change.
+	Set(key string, v interface{}) error
+	// Namespace returns a Config scoped to prefix, so commands can store
+	// keys without colliding with one another.
+	Namespace(prefix string) Config
+}
+
+// KVBackend is the persistence layer behind a Config. Implement this to
+// plug in Redis, SQL, or any other store without changing command code.
+type KVBackend interface {
+	Load() (map[string]interface{}, error)
+	Save(map[string]interface{}) error
+}
+
+// JSONFileBackend persists config as a JSON file, the original finch
+// behavior.
+type JSONFileBackend struct {
+	// FileName is the path to read and write. Defaults to the
+	// FINCH_CONFIG environment variable, or "config.json".
+	FileName string
+}
+
+func (b *JSONFileBackend) fileName() string {
+	if b.FileName != "" {
+		return b.FileName
+	}
+
+	if v := os.Getenv("FINCH_CONFIG"); v != "" {
+		return v
+	}
+
+	return "config.json"
+}
+
+// Load implements KVBackend.
+func (b *JSONFileBackend) Load() (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	f, err := ioutil.ReadFile(b.fileName())
+	if err != nil {
+		return data, nil
+	}
+
+	if err := json.Unmarshal(f, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Save implements KVBackend.
+func (b *JSONFileBackend) Save(data map[string]interface{}) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		if sentryEnabled {
+			raven.CaptureErrorAndWait(err, nil)
+		}
+
+		return err
+	}
+
+	return ioutil.WriteFile(b.fileName(), out, 0600)
+}
+
+// MemoryBackend is a KVBackend that keeps data in memory, useful for
+// tests and short-lived bots that don't need persistence.
+type MemoryBackend struct {
+	data map[string]interface{}
+}
+
+// Load implements KVBackend.
+func (b *MemoryBackend) Load() (map[string]interface{}, error) {
+	if b.data == nil {
+		b.data = map[string]interface{}{}
+	}
+
+	return b.data, nil
+}
+
+// Save implements KVBackend.
+func (b *MemoryBackend) Save(data map[string]interface{}) error {
+	b.data = data
+	return nil
+}
+
+// config is the default Config implementation, backed by a KVBackend.
+//
+// mu guards data. It's a pointer, rather than an embedded sync.RWMutex,
+// so every Config returned by Namespace shares the same lock as the
+// data map it aliases - Get/Set are called from whatever worker
+// happens to be handling a chat's update, and Run's worker pool
+// dispatches those concurrently by design.
+type config struct {
+	backend   KVBackend
+	mu        *sync.RWMutex
+	data      map[string]interface{}
+	prefix    string
+	envPrefix string
+}
+
+// NewConfig returns a Config persisted through backend.
+func NewConfig(backend KVBackend) (Config, error) {
+	data, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config{backend: backend, mu: &sync.RWMutex{}, data: data, envPrefix: "FINCH_"}, nil
+}
+
+// LoadConfig loads the saved config, if it exists.
+//
+// It looks for a FINCH_CONFIG environmental variable, before falling
+// back to a file named config.json.
+func LoadConfig() (Config, error) {
+	return NewConfig(&JSONFileBackend{})
+}
+
+func (c *config) key(key string) string {
+	if c.prefix == "" {
+		return key
+	}
+
+	return c.prefix + "." + key
+}
+
+func (c *config) envKey(key string) string {
+	return c.envPrefix + strings.ToUpper(strings.Replace(c.key(key), ".", "_", -1))
+}
+
+// Get implements Config.
+//
+// It does not consult the environment: an env var can only ever hold a
+// string, and applying it here would corrupt the type callers expect
+// back for keys storing structured data (e.g. a command's cached
+// state). Only the scalar getters below fall back to the environment.
+func (c *config) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v, ok := c.data[c.key(key)]
+	return v, ok
+}
+
+// GetString implements Config.
+func (c *config) GetString(key string) (string, bool) {
+	if v, ok := os.LookupEnv(c.envKey(key)); ok {
+		return v, true
+	}
+
+	v, ok := c.Get(key)
+	if !ok {
+		return "", false
+	}
+
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+
+	return "", false
+}
+
+// GetBool implements Config.
+func (c *config) GetBool(key string) bool {
+	if v, ok := os.LookupEnv(c.envKey(key)); ok {
+		parsed, _ := strconv.ParseBool(v)
+		return parsed
+	}
+
+	v, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		parsed, _ := strconv.ParseBool(b)
+		return parsed
+	default:
+		return false
+	}
+}
+
+// GetInt implements Config.
+func (c *config) GetInt(key string) int {
+	if v, ok := os.LookupEnv(c.envKey(key)); ok {
+		parsed, _ := strconv.Atoi(v)
+		return parsed
+	}
+
+	v, ok := c.Get(key)
+	if !ok {
+		return 0
+	}
+
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	case string:
+		parsed, _ := strconv.Atoi(n)
+		return parsed
+	default:
+		return 0
+	}
+}
+
+// Set implements Config.
+//
+// The lock is held across the backend.Save call, not just the map
+// write, so a concurrent Set can't mutate data while it's being
+// marshaled.
+func (c *config) Set(key string, v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data[c.key(key)] = v
+	return c.backend.Save(c.data)
+}
+
+// Namespace implements Config.
+func (c *config) Namespace(prefix string) Config {
+	ns := c.key(prefix)
+
+	return &config{
+		backend:   c.backend,
+		mu:        c.mu,
+		data:      c.data,
+		prefix:    ns,
+		envPrefix: c.envPrefix,
+	}
+}
@@ -0,0 +1,136 @@
+package finch
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// DefaultWorkers is the number of worker goroutines Run starts when
+// FinchOptions.Workers is unset.
+const DefaultWorkers = 8
+
+// DefaultQueueSize is the per-queue buffer Run uses when
+// FinchOptions.QueueSize is unset. A non-zero default lets a
+// WebhookPoller queue a burst of updates instead of shedding them the
+// instant every worker happens to be busy.
+const DefaultQueueSize = 64
+
+// latencyBucketBounds are the upper bounds, in seconds, of the handler
+// latency histogram returned by Stats.
+var latencyBucketBounds = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// LatencyBucketBounds returns the upper bounds, in seconds, that
+// Stats.LatencyBuckets counts against - LatencyBuckets[i] counts
+// handler calls that completed in under LatencyBucketBounds()[i]
+// seconds.
+func LatencyBucketBounds() []float64 {
+	return append([]float64(nil), latencyBucketBounds...)
+}
+
+// Stats is a snapshot of Finch's dispatch counters, suitable for
+// exposing through a Prometheus-style /metrics endpoint.
+type Stats struct {
+	// Received is the number of updates a LongPoller or WebhookPoller
+	// (also when wrapped in a MiddlewarePoller) has read and passed on;
+	// updates a WebhookPoller rejects count as Dropped instead. Updates
+	// from other Poller implementations are not counted.
+	Received uint64
+	// Dispatched is the number of updates handed to a worker.
+	Dispatched uint64
+	// Dropped is the number of updates rejected because the queue was full.
+	Dropped uint64
+	// InFlight is the number of updates currently being handled.
+	InFlight int64
+	// LatencyBuckets mirrors a Prometheus histogram, see LatencyBucketBounds.
+	LatencyBuckets []uint64
+}
+
+// dispatcher holds the atomic counters behind Finch.Stats.
+type dispatcher struct {
+	received   uint64
+	dispatched uint64
+	dropped    uint64
+	inFlight   int64
+	buckets    []uint64
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{buckets: make([]uint64, len(latencyBucketBounds))}
+}
+
+func (d *dispatcher) observe(dur time.Duration) {
+	seconds := dur.Seconds()
+	for i, bound := range latencyBucketBounds {
+		if seconds <= bound {
+			atomic.AddUint64(&d.buckets[i], 1)
+		}
+	}
+}
+
+func (d *dispatcher) snapshot() Stats {
+	buckets := make([]uint64, len(d.buckets))
+	for i := range d.buckets {
+		buckets[i] = atomic.LoadUint64(&d.buckets[i])
+	}
+
+	return Stats{
+		Received:       atomic.LoadUint64(&d.received),
+		Dispatched:     atomic.LoadUint64(&d.dispatched),
+		Dropped:        atomic.LoadUint64(&d.dropped),
+		InFlight:       atomic.LoadInt64(&d.inFlight),
+		LatencyBuckets: buckets,
+	}
+}
+
+// Stats returns a snapshot of Finch's dispatch counters. Safe to call
+// concurrently with Run.
+func (f *Finch) Stats() Stats {
+	if f.dispatcher == nil {
+		return Stats{LatencyBuckets: make([]uint64, len(latencyBucketBounds))}
+	}
+
+	return f.dispatcher.snapshot()
+}
+
+// worker drains queue, running every update through handler, until
+// queue is closed or ctx is done.
+func (f *Finch) worker(ctx context.Context, queue <-chan tgbotapi.Update, handler Handler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-queue:
+			if !ok {
+				return
+			}
+
+			atomic.AddUint64(&f.dispatcher.dispatched, 1)
+			atomic.AddInt64(&f.dispatcher.inFlight, 1)
+			f.wg.Add(1)
+
+			start := time.Now()
+			handler(ctx, update)
+			f.dispatcher.observe(time.Since(start))
+
+			atomic.AddInt64(&f.dispatcher.inFlight, -1)
+			f.wg.Done()
+		}
+	}
+}
+
+// hashChatID maps a chat ID onto one of n worker queues, so every update
+// from the same chat lands on the same worker and is processed in order.
+func hashChatID(chatID int64, n int) int {
+	h := fnv.New32a()
+	var b [8]byte
+	for i := 0; i < 8; i++ {
+		b[i] = byte(chatID >> (8 * i))
+	}
+	h.Write(b[:])
+
+	return int(h.Sum32() % uint32(n))
+}
@@ -2,73 +2,64 @@
 package finch
 
 import (
-	"encoding/json"
+	"context"
+	"sync"
 
 	"github.com/getsentry/raven-go"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 )
 
-// Config is a type used for storing configuration information.
-type Config map[string]interface{}
-
 var bot *Finch
 
 var sentryEnabled bool = false
 
-// LoadConfig loads the saved config, if it exists.
-//
-// It looks for a FINCH_CONFIG environmental variable,
-// before falling back to a file name config.json.
-func LoadConfig() (*Config, error) {
-	fileName := os.Getenv("FINCH_CONFIG")
-	if fileName == "" {
-		fileName = "config.json"
-	}
-
-	f, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		return &Config{}, nil
-	}
-
-	var cfg Config
-	json.Unmarshal(f, &cfg)
-
-	return &cfg, nil
-}
-
-// Save saves the current Config struct.
-//
-// It uses the same file as LoadConfig.
-func (c *Config) Save() error {
-	b, err := json.Marshal(c)
-	if err != nil {
-		if sentryEnabled {
-			raven.CaptureErrorAndWait(err, nil)
-		}
-
-		return err
-	}
-
-	fileName := os.Getenv("FINCH_CONFIG")
-	if fileName == "" {
-		fileName = "config.json"
-	}
-
-	return ioutil.WriteFile(fileName, b, 0600)
-}
-
 // Finch is a Telegram Bot, including API, Config, and Commands.
 type Finch struct {
 	API      *tgbotapi.BotAPI
 	Config   Config
 	Commands []*CommandState
 	Inline   InlineCommand
+
+	// Poller is the source of updates used by Run. If nil when Run is
+	// called, it defaults to a LongPoller.
+	Poller Poller
+
+	// ParseMode is applied by SendMessage/Send to a MessageConfig that
+	// doesn't already set one. Other Chattables are sent unchanged. See
+	// FinchOptions.ParseMode.
+	ParseMode string
+
+	// Workers is the number of goroutines Run uses to handle updates.
+	// Defaults to DefaultWorkers.
+	Workers int
+	// QueueSize is how many updates each of Run's queues buffers before
+	// applying backpressure: the one queue shared by all workers, or,
+	// with PerChatOrdering, each worker's own queue. Defaults to
+	// DefaultQueueSize. See FinchOptions.QueueSize.
+	QueueSize int
+	// PerChatOrdering hashes each update's chat ID onto a single worker,
+	// so updates from one chat are always handled in order, while
+	// different chats still run in parallel.
+	PerChatOrdering bool
+
+	middleware []Middleware
+	wg         sync.WaitGroup
+	dispatcher *dispatcher
+
+	// commandsMu guards plugins and mutations of Commands made by
+	// LoadPlugin/LoadPluginDir/UnloadPlugin, so concurrent (un)loads
+	// don't race on the plugins map or the Commands slice header.
+	//
+	// It does NOT make hot-loading safe while Run is live: commandRouter
+	// reads Commands on every dispatched update with no synchronization
+	// at all. (Un)load plugins before calling Run, or after Shutdown has
+	// drained in-flight handlers - see LoadPlugin.
+	commandsMu sync.Mutex
+	plugins    map[string][]*CommandState
 }
 
 // NewFinch returns a new Finch instance, with Telegram API setup.
@@ -79,72 +70,137 @@ func NewFinch(token string, debug bool) *Finch {
 // NewFinchWithClient returns a new Finch instance,
 // using a different net/http Client.
 func NewFinchWithClient(token string, client *http.Client, debug bool) *Finch {
-	bot = &Finch{}
+	return NewFinchWithOptions(FinchOptions{Token: token, Client: client, Debug: debug})
+}
+
+// Run initializes commands, then drives whichever Poller is configured
+// until ctx is cancelled. It replaces the update-source-specific Start
+// and StartWebhook methods, letting callers swap in a LongPoller,
+// WebhookPoller, or a custom Poller (e.g. one replaying fixtures in
+// tests) without changing how updates are dispatched.
+func (f *Finch) Run(ctx context.Context) {
+	if f.Poller == nil {
+		f.Poller = &LongPoller{}
+	}
 
-	api, err := tgbotapi.NewBotAPIWithClient(token, client)
-	if err != nil {
-		panic(err)
+	if dsn, ok := f.Config.GetString("sentry_dsn"); ok {
+		sentryEnabled = true
+		raven.SetDSN(dsn)
 	}
 
-	bot.API = api
-	bot.Commands = commands
-	bot.Inline = inline
-	bot.API.Debug = debug
+	// Recover must wrap the entire middleware chain, not just the base
+	// handler, so a panic anywhere in caller-registered middleware is
+	// also caught - prepend it rather than using Use, which appends.
+	f.middleware = append([]Middleware{Recover(f.reportPanic)}, f.middleware...)
 
-	c, _ := LoadConfig()
-	bot.Config = *c
+	f.commandInit()
 
-	return bot
-}
+	handler := f.handler()
+	f.dispatcher = newDispatcher()
 
-// Start initializes commands, and starts listening for messages.
-func (f *Finch) Start() {
-	f.API.SetWebhook(tgbotapi.NewWebhook(""))
-	if v, ok := f.Config["sentry_dsn"]; ok {
-		sentryEnabled = true
-		raven.SetDSN(v.(string))
+	workers := f.Workers
+	if workers == 0 {
+		workers = DefaultWorkers
 	}
 
-	f.commandInit()
+	queueSize := f.QueueSize
+	if queueSize == 0 {
+		queueSize = DefaultQueueSize
+	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 86400
+	stop := make(chan struct{})
 
-	updates, err := f.API.GetUpdatesChan(u)
-	if err != nil {
-		if sentryEnabled {
-			raven.CaptureErrorAndWait(err, nil)
+	if f.PerChatOrdering {
+		queues := make([]chan tgbotapi.Update, workers)
+		for i := range queues {
+			queues[i] = make(chan tgbotapi.Update, queueSize)
+			go f.worker(ctx, queues[i], handler)
 		}
 
-		log.Fatal(err)
+		dest := make(chan tgbotapi.Update)
+		go f.Poller.Poll(f, dest, stop)
+
+		for {
+			select {
+			case <-ctx.Done():
+				close(stop)
+				return
+			case update, ok := <-dest:
+				if !ok {
+					return
+				}
+
+				queues[hashChatID(updateChatID(update), workers)] <- update
+			}
+		}
 	}
 
-	for update := range updates {
-		go f.commandRouter(update)
+	queue := make(chan tgbotapi.Update, queueSize)
+	for i := 0; i < workers; i++ {
+		go f.worker(ctx, queue, handler)
 	}
+
+	// Poller writes directly into the bounded queue: a LongPoller simply
+	// blocks once it's full, giving natural backpressure, while a
+	// WebhookPoller can instead reject the request so Telegram retries.
+	go f.Poller.Poll(f, queue, stop)
+
+	<-ctx.Done()
+	close(stop)
+}
+
+// reportPanic is the default Recover reporter: it always logs, and also
+// forwards to Sentry/raven when a sentry_dsn is configured.
+func (f *Finch) reportPanic(err error, u tgbotapi.Update) {
+	log.Printf("finch: recovered panic handling update %d: %v", u.UpdateID, err)
+
+	if sentryEnabled {
+		raven.CaptureError(err, nil)
+	}
+}
+
+// Shutdown waits for in-flight handlers dispatched by Run to finish, or
+// for ctx to be done, whichever comes first. Call it after cancelling
+// Run's context (e.g. on SIGTERM) to avoid dropping messages mid-flight.
+func (f *Finch) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start initializes commands, and starts listening for messages.
+//
+// It is a thin shim over Run using a LongPoller, kept for backwards
+// compatibility.
+func (f *Finch) Start() {
+	f.API.SetWebhook(tgbotapi.NewWebhook(""))
+	f.Poller = &LongPoller{Timeout: 86400}
+	f.Run(context.Background())
 }
 
 // StartWebhook initializes commands,
-// then registers a webhook for the bot to listen on
+// then registers a webhook for the bot to listen on.
+//
+// It is a thin shim over Run using a WebhookPoller, kept for backwards
+// compatibility.
 func (f *Finch) StartWebhook(domainName string, endpoint string, listenPort string) {
 	log.Printf("Authorized on account @%s", bot.API.Self.UserName)
-	log.Printf("Webhook Url: " + domainName + endpoint)
-	_, err := bot.API.SetWebhook(tgbotapi.NewWebhook(domainName + endpoint))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	f.commandInit()
-	updates := f.API.ListenForWebhook(endpoint)
-	go http.ListenAndServe(":"+listenPort, nil)
 
-	for update := range updates {
-		if bot.API.Debug {
-			log.Printf("%+v\n", update)
-		}
-		go f.commandRouter(update)
+	f.Poller = &WebhookPoller{
+		Domain:     domainName,
+		Endpoint:   endpoint,
+		ListenAddr: ":" + listenPort,
 	}
-
+	f.Run(context.Background())
 }
 
 // SendMessage sends a message with various changes, and does not return the Message.
@@ -152,6 +208,9 @@ func (f *Finch) StartWebhook(domainName string, endpoint string, listenPort stri
 // At some point, this may do more handling as needed.
 func (f *Finch) SendMessage(message tgbotapi.MessageConfig) error {
 	message.Text = strings.Replace(message.Text, "@@", "@"+f.API.Self.UserName, -1)
+	if message.ParseMode == "" {
+		message.ParseMode = f.ParseMode
+	}
 
 	_, err := f.API.Send(message)
 	if err != nil && sentryEnabled {
@@ -172,6 +231,13 @@ func (f *Finch) QuickReply(message tgbotapi.Message, text string) error {
 //
 // At some point, this may do more handling as needed.
 func (f *Finch) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if f.ParseMode != "" {
+		if mc, ok := c.(tgbotapi.MessageConfig); ok && mc.ParseMode == "" {
+			mc.ParseMode = f.ParseMode
+			c = mc
+		}
+	}
+
 	msg, err := f.API.Send(c)
 	if err != nil && sentryEnabled {
 		raven.CaptureError(err, nil)
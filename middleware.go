@@ -0,0 +1,135 @@
+package finch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Handler processes a single update.
+type Handler func(ctx context.Context, u tgbotapi.Update) error
+
+// Middleware wraps a Handler to add cross-cutting behavior, such as
+// logging, panic recovery, or timeouts.
+type Middleware func(next Handler) Handler
+
+// Use registers middleware to run, in order, around every update
+// dispatched by Run. Middleware must be registered before Run is called.
+func (f *Finch) Use(mw ...Middleware) {
+	f.middleware = append(f.middleware, mw...)
+}
+
+// handler builds the final Handler for a dispatched update by wrapping
+// commandRouter with every registered middleware, outermost first.
+func (f *Finch) handler() Handler {
+	h := Handler(func(ctx context.Context, u tgbotapi.Update) error {
+		f.commandRouter(u)
+		return nil
+	})
+
+	for i := len(f.middleware) - 1; i >= 0; i-- {
+		h = f.middleware[i](h)
+	}
+
+	return h
+}
+
+// Recover catches panics raised by a handler and forwards them to
+// reporter instead of crashing the dispatch goroutine. Run registers it
+// unconditionally with a reporter that logs, and also forwards to
+// Sentry/raven when a sentry_dsn is configured.
+func Recover(reporter func(err error, u tgbotapi.Update)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, u tgbotapi.Update) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("finch: recovered panic: %v", r)
+					if reporter != nil {
+						reporter(err, u)
+					}
+				}
+			}()
+
+			return next(ctx, u)
+		}
+	}
+}
+
+// Logger logs how long each update took to handle, and any error it
+// returned.
+func Logger(next Handler) Handler {
+	return func(ctx context.Context, u tgbotapi.Update) error {
+		start := time.Now()
+		err := next(ctx, u)
+		log.Printf("finch: update %d handled in %s, err=%v", u.UpdateID, time.Since(start), err)
+		return err
+	}
+}
+
+// Timeout cancels a handler's context after d. It only cancels the
+// context: it still waits for next to return, so a handler that does
+// not watch ctx (the command router does not) keeps its worker busy
+// for as long as it runs.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, u tgbotapi.Update) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			return next(ctx, u)
+		}
+	}
+}
+
+// PerChatSerialize ensures updates from a single chat are handled one at
+// a time, in order, while different chats continue to run in parallel.
+func PerChatSerialize() Middleware {
+	var mu sync.Mutex
+	locks := map[int64]*sync.Mutex{}
+
+	chatLock := func(chatID int64) *sync.Mutex {
+		mu.Lock()
+		defer mu.Unlock()
+
+		l, ok := locks[chatID]
+		if !ok {
+			l = &sync.Mutex{}
+			locks[chatID] = l
+		}
+
+		return l
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, u tgbotapi.Update) error {
+			l := chatLock(updateChatID(u))
+			l.Lock()
+			defer l.Unlock()
+
+			return next(ctx, u)
+		}
+	}
+}
+
+// updateChatID returns the chat an update belongs to, or 0 if it isn't
+// associated with one.
+func updateChatID(u tgbotapi.Update) int64 {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.ID
+	case u.EditedMessage != nil:
+		return u.EditedMessage.Chat.ID
+	case u.ChannelPost != nil:
+		return u.ChannelPost.Chat.ID
+	case u.EditedChannelPost != nil:
+		return u.EditedChannelPost.Chat.ID
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		return u.CallbackQuery.Message.Chat.ID
+	default:
+		return 0
+	}
+}
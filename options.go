@@ -0,0 +1,145 @@
+package finch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// DefaultAPIEndpoint is the Bot API endpoint used unless
+// FinchOptions.APIEndpoint overrides it.
+const DefaultAPIEndpoint = "https://api.telegram.org/bot%s/%s"
+
+// defaultAPIHost is the host of DefaultAPIEndpoint.
+const defaultAPIHost = "api.telegram.org"
+
+// FinchOptions configures a Finch instance built with NewFinchWithOptions.
+type FinchOptions struct {
+	// Token is the bot's Telegram API token.
+	Token string
+	// APIEndpoint is the Bot API server to talk to, formatted with the
+	// token and method name, e.g. DefaultAPIEndpoint. Defaults to
+	// DefaultAPIEndpoint; point it at a self-hosted Bot API server for
+	// local deployments handling large files or needing higher rate
+	// limits.
+	//
+	// The pinned tgbotapi dependency hardcodes its own copy of this
+	// format as an unexported const, with no var, field, or setter to
+	// repoint a *BotAPI elsewhere, so a non-default APIEndpoint is
+	// applied by installing a Client RoundTripper that rewrites the
+	// scheme and host of every outgoing request addressed to the default
+	// Bot API host to match - requests to other hosts pass through
+	// unchanged, and the request
+	// path tgbotapi builds (bot<token>/<method>) is left untouched, so a
+	// custom endpoint must serve that same path layout.
+	APIEndpoint string
+	// Client is the http.Client used for all outbound requests.
+	// Defaults to &http.Client{}.
+	Client *http.Client
+	// Debug enables verbose logging of the underlying tgbotapi client.
+	Debug bool
+	// Config stores bot state. Defaults to LoadConfig().
+	Config Config
+	// ParseMode is applied by SendMessage/Send to a MessageConfig that
+	// doesn't already set one, e.g. tgbotapi.ModeMarkdown. Other
+	// Chattables are sent unchanged.
+	ParseMode string
+	// Workers is the number of goroutines Run uses to handle updates.
+	// Defaults to DefaultWorkers.
+	Workers int
+	// QueueSize is how many updates each of Run's queues buffers before
+	// applying backpressure (one shared queue, or one per worker with
+	// PerChatOrdering): a LongPoller blocks, a WebhookPoller
+	// returns HTTP 503 so Telegram retries. Defaults to
+	// DefaultQueueSize.
+	QueueSize int
+	// PerChatOrdering hashes each update's chat ID onto a single worker,
+	// so updates from one chat are always handled in order.
+	PerChatOrdering bool
+}
+
+// NewFinchWithOptions returns a new Finch instance configured by opts.
+func NewFinchWithOptions(opts FinchOptions) *Finch {
+	bot = &Finch{}
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	endpoint := opts.APIEndpoint
+	if endpoint == "" {
+		endpoint = DefaultAPIEndpoint
+	}
+
+	if endpoint != DefaultAPIEndpoint {
+		target, err := url.Parse(fmt.Sprintf(endpoint, "token", "method"))
+		if err != nil {
+			panic(err)
+		}
+
+		rewritten := *client
+		rewritten.Transport = &apiEndpointTransport{base: client.Transport, target: target}
+		client = &rewritten
+	}
+
+	api, err := tgbotapi.NewBotAPIWithClient(opts.Token, client)
+	if err != nil {
+		panic(err)
+	}
+
+	bot.API = api
+	bot.Commands = commands
+	bot.Inline = inline
+	bot.API.Debug = opts.Debug
+	bot.ParseMode = opts.ParseMode
+	bot.Workers = opts.Workers
+	bot.QueueSize = opts.QueueSize
+	bot.PerChatOrdering = opts.PerChatOrdering
+
+	bot.Config = opts.Config
+	if bot.Config == nil {
+		bot.Config, _ = LoadConfig()
+	}
+
+	return bot
+}
+
+// HTTPClient returns the http.Client the framework was configured with,
+// so commands making their own outbound calls can reuse the same
+// client, proxy, and timeouts.
+func (f *Finch) HTTPClient() *http.Client {
+	return f.API.Client
+}
+
+// apiEndpointTransport redirects outgoing Bot API requests to a
+// self-hosted server, by swapping in the scheme and host of a custom
+// FinchOptions.APIEndpoint on requests addressed to the default Bot API
+// host. Requests to any other host pass through as they are, and a
+// redirected request is otherwise left untouched (notably
+// its bot<token>/<method> path, which tgbotapi builds against its own
+// hardcoded endpoint const) untouched.
+type apiEndpointTransport struct {
+	base   http.RoundTripper
+	target *url.URL
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *apiEndpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if req.URL.Host != defaultAPIHost {
+		return base.RoundTrip(req)
+	}
+
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,129 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package finch
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+)
+
+// LoadPlugin opens a Go plugin built with `go build -buildmode=plugin`
+// and registers every Command it exports, exactly as if the command had
+// been compiled in and registered via RegisterCommand from an init()
+// func.
+//
+// The plugin must export a `func Commands() []finch.Command` symbol.
+// Each returned Command is given its own CommandState and a Config
+// namespaced to the plugin's path, so two plugins (or a plugin and the
+// host binary) never collide on a config key.
+//
+// LoadPlugin/UnloadPlugin are NOT safe to call while Run is live:
+// commandRouter reads Finch.Commands on every dispatched update without
+// synchronization, so appending/removing entries concurrently races
+// with that read. Load and unload plugins before calling Run, or after
+// Shutdown has returned and drained in-flight handlers.
+func (f *Finch) LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+
+	sym, err := p.Lookup("Commands")
+	if err != nil {
+		return err
+	}
+
+	commandsFn, ok := sym.(func() []Command)
+	if !ok {
+		return fmt.Errorf("finch: plugin %s does not export `func Commands() []finch.Command`", path)
+	}
+
+	f.commandsMu.Lock()
+	defer f.commandsMu.Unlock()
+
+	pluginFinch := &Finch{
+		API:             f.API,
+		Config:          f.Config.Namespace(path),
+		Commands:        f.Commands,
+		Inline:          f.Inline,
+		Poller:          f.Poller,
+		ParseMode:       f.ParseMode,
+		Workers:         f.Workers,
+		QueueSize:       f.QueueSize,
+		PerChatOrdering: f.PerChatOrdering,
+	}
+
+	var states []*CommandState
+	for _, c := range commandsFn() {
+		state := &CommandState{Command: c}
+
+		if err := c.Init(state, pluginFinch); err != nil {
+			return fmt.Errorf("finch: initializing command from plugin %s: %w", path, err)
+		}
+
+		states = append(states, state)
+	}
+
+	if f.plugins == nil {
+		f.plugins = map[string][]*CommandState{}
+	}
+	f.plugins[path] = states
+
+	f.Commands = append(f.Commands, states...)
+
+	return nil
+}
+
+// LoadPluginDir calls LoadPlugin for every *.so file in dir.
+func (f *Finch) LoadPluginDir(dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range matches {
+		if err := f.LoadPlugin(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnloadPlugin removes the commands registered by the plugin at path
+// from the router.
+//
+// Go's plugin package has no way to unload a .so from the running
+// process, so this only stops the plugin's commands from being
+// dispatched - the binary itself stays mapped until the process exits.
+func (f *Finch) UnloadPlugin(path string) error {
+	f.commandsMu.Lock()
+	defer f.commandsMu.Unlock()
+
+	states, ok := f.plugins[path]
+	if !ok {
+		return fmt.Errorf("finch: plugin %s is not loaded", path)
+	}
+
+	remaining := make([]*CommandState, 0, len(f.Commands))
+	for _, c := range f.Commands {
+		unloaded := false
+		for _, s := range states {
+			if c == s {
+				unloaded = true
+				break
+			}
+		}
+
+		if !unloaded {
+			remaining = append(remaining, c)
+		}
+	}
+
+	f.Commands = remaining
+	delete(f.plugins, path)
+
+	return nil
+}
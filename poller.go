@@ -0,0 +1,167 @@
+package finch
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/getsentry/raven-go"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Poller is a source of Telegram updates that Finch.Run can drive.
+//
+// Poll should write updates to dest until stop is closed, at which point
+// it should stop producing updates and return. Implementations are free
+// to read from wherever they like - Telegram's long-poll API, a webhook,
+// a queue, or fixtures replayed in a test.
+type Poller interface {
+	Poll(f *Finch, dest chan<- tgbotapi.Update, stop <-chan struct{})
+}
+
+// LongPoller polls Telegram's getUpdates endpoint, the traditional way of
+// receiving updates.
+type LongPoller struct {
+	// Timeout is the long-poll timeout, in seconds. Defaults to 86400.
+	Timeout int
+	// Offset is the update ID to start polling from.
+	Offset int
+}
+
+// Poll implements Poller.
+func (p *LongPoller) Poll(f *Finch, dest chan<- tgbotapi.Update, stop <-chan struct{}) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 86400
+	}
+
+	u := tgbotapi.NewUpdate(p.Offset)
+	u.Timeout = timeout
+
+	updates, err := f.API.GetUpdatesChan(u)
+	if err != nil {
+		if sentryEnabled {
+			raven.CaptureErrorAndWait(err, nil)
+		}
+
+		log.Fatal(err)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+
+			atomic.AddUint64(&f.dispatcher.received, 1)
+
+			// A long-poll source naturally backpressures: blocking here
+			// just delays fetching the next batch from Telegram.
+			select {
+			case dest <- update:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// WebhookPoller registers a webhook with Telegram and serves it over HTTP.
+type WebhookPoller struct {
+	// Domain is the externally reachable domain the webhook is registered
+	// under, e.g. "https://example.com".
+	Domain string
+	// Endpoint is the path Telegram will POST updates to, e.g. "/bot-token".
+	Endpoint string
+	// ListenAddr is passed to http.ListenAndServe, e.g. ":8443".
+	ListenAddr string
+	// Certificate is an optional path to a public certificate to upload
+	// alongside the webhook, for self-signed deployments.
+	Certificate string
+}
+
+// Poll implements Poller.
+func (p *WebhookPoller) Poll(f *Finch, dest chan<- tgbotapi.Update, stop <-chan struct{}) {
+	webhook := tgbotapi.NewWebhook(p.Domain + p.Endpoint)
+	if p.Certificate != "" {
+		webhook = tgbotapi.NewWebhookWithCert(p.Domain+p.Endpoint, p.Certificate)
+	}
+
+	log.Printf("Webhook Url: " + p.Domain + p.Endpoint)
+
+	if _, err := f.API.SetWebhook(webhook); err != nil {
+		if sentryEnabled {
+			raven.CaptureErrorAndWait(err, nil)
+		}
+
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(p.Endpoint, func(w http.ResponseWriter, r *http.Request) {
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if f.API.Debug {
+			log.Printf("%+v\n", update)
+		}
+
+		select {
+		case dest <- update:
+			atomic.AddUint64(&f.dispatcher.received, 1)
+			w.WriteHeader(http.StatusOK)
+		default:
+			// The queue is full; reject so Telegram retries the update
+			// later instead of us dropping or blocking it.
+			atomic.AddUint64(&f.dispatcher.dropped, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	srv := &http.Server{Addr: p.ListenAddr, Handler: mux}
+	go srv.ListenAndServe()
+
+	<-stop
+	srv.Close()
+}
+
+// MiddlewarePoller wraps another Poller, passing every update through
+// Filter before it reaches dest. Returning false drops the update - useful
+// for discarding stale updates, or rate limiting a noisy chat, without
+// touching the underlying update source.
+type MiddlewarePoller struct {
+	Poller Poller
+	Filter func(tgbotapi.Update) bool
+}
+
+// Poll implements Poller.
+func (p *MiddlewarePoller) Poll(f *Finch, dest chan<- tgbotapi.Update, stop <-chan struct{}) {
+	filtered := make(chan tgbotapi.Update)
+	go p.Poller.Poll(f, filtered, stop)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case update, ok := <-filtered:
+			if !ok {
+				return
+			}
+
+			if p.Filter == nil || p.Filter(update) {
+				select {
+				case dest <- update:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}
+}
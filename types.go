@@ -78,15 +78,14 @@ func (CommandBase) Execute(tgbotapi.Update) error { return nil }
 // you are expecting to get a reply that is not a command.
 func (CommandBase) ExecuteKeyboard(tgbotapi.Update) error { return nil }
 
-// Get fetches an item from the Config struct.
-func (cmd CommandBase) Get(key string) interface{} {
-	return cmd.Finch.Config[key]
+// Get fetches an item from the Config, and whether it was set.
+func (cmd CommandBase) Get(key string) (interface{}, bool) {
+	return cmd.Finch.Config.Get(key)
 }
 
-// Set sets an item in the Config struct, then saves it.
-func (cmd CommandBase) Set(key string, value interface{}) {
-	cmd.Finch.Config[key] = value
-	cmd.Finch.Config.Save()
+// Set sets an item in the Config, then saves it.
+func (cmd CommandBase) Set(key string, value interface{}) error {
+	return cmd.Finch.Config.Set(key, value)
 }
 
 // CommandState is the current state of a command.